@@ -0,0 +1,695 @@
+package main
+
+import (
+    "bytes"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "sync"
+    "sync/atomic"
+    "testing"
+    "time"
+
+    "github.com/MagnusChase03/GoFast/pkg/manifest"
+)
+
+// TestDownloadChunksVerifiesChecksum exercises --checksum end to end against
+// a local server that supports Range requests, guarding against the file
+// being opened write-only and checksum read-back failing silently.
+func TestDownloadChunksVerifiesChecksum(t *testing.T) {
+    content := make([]byte, 256*1024);
+    for i := range content {
+        content[i] = byte(i);
+    }
+    sum := sha256.Sum256(content);
+    checksum, err := NewChecksumVerifier("sha256:" + hex.EncodeToString(sum[:]));
+    if err != nil {
+        t.Fatalf("NewChecksumVerifier failed: %v", err);
+    }
+
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(content));
+    }));
+    defer server.Close();
+
+    output := filepath.Join(t.TempDir(), "out.bin");
+    getter := NewGetter(4, 1);
+    chunks := GetDownloadChunks(uint64(len(content)), 4);
+    if err := getter.DownloadChunks(server.URL, chunks, output, checksum, nil); err != nil {
+        t.Fatalf("DownloadChunks failed: %v", err);
+    }
+
+    got, err := os.ReadFile(output);
+    if err != nil {
+        t.Fatalf("failed to read downloaded file: %v", err);
+    }
+    if !bytes.Equal(got, content) {
+        t.Fatalf("downloaded content does not match the served content");
+    }
+}
+
+// TestDownloadChunksSingleStreamFallbackVerifiesChecksum exercises
+// --checksum against a server that doesn't support Range requests, which
+// routes through downloadSingleStream rather than the chunked path.
+func TestDownloadChunksSingleStreamFallbackVerifiesChecksum(t *testing.T) {
+    content := []byte("the quick brown fox jumps over the lazy dog");
+    sum := sha256.Sum256(content);
+
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Accept-Ranges", "none");
+        w.Write(content);
+    }));
+    defer server.Close();
+
+    getter := NewGetter(4, 1);
+
+    good, err := NewChecksumVerifier("sha256:" + hex.EncodeToString(sum[:]));
+    if err != nil {
+        t.Fatalf("NewChecksumVerifier failed: %v", err);
+    }
+    output := filepath.Join(t.TempDir(), "out.bin");
+    if err := getter.DownloadChunks(server.URL, nil, output, good, nil); err != nil {
+        t.Fatalf("DownloadChunks failed: %v", err);
+    }
+    got, err := os.ReadFile(output);
+    if err != nil {
+        t.Fatalf("failed to read downloaded file: %v", err);
+    }
+    if !bytes.Equal(got, content) {
+        t.Fatalf("downloaded content does not match the served content");
+    }
+
+    bad, err := NewChecksumVerifier("sha256:" + strings.Repeat("0", 64));
+    if err != nil {
+        t.Fatalf("NewChecksumVerifier failed: %v", err);
+    }
+    badOutput := filepath.Join(t.TempDir(), "bad.bin");
+    if err := getter.DownloadChunks(server.URL, nil, badOutput, bad, nil); err == nil {
+        t.Fatalf("expected checksum verification to fail for a wrong checksum");
+    }
+    if _, err := os.Stat(badOutput); !os.IsNotExist(err) {
+        t.Fatalf("corrupt file was not deleted after checksum verification failed");
+    }
+}
+
+// TestDownloadChunksTruncatesStaleOutput guards against a fresh (non-resume)
+// download leaving an earlier, larger download's tail bytes on disk when
+// both target the same output path.
+func TestDownloadChunksTruncatesStaleOutput(t *testing.T) {
+    bigContent := bytes.Repeat([]byte{0xAA}, 10*1024);
+    smallContent := []byte("small");
+
+    serveContent := bigContent;
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(serveContent));
+    }));
+    defer server.Close();
+
+    output := filepath.Join(t.TempDir(), "out.bin");
+    getter := NewGetter(4, 1);
+
+    chunks := GetDownloadChunks(uint64(len(bigContent)), 4);
+    if err := getter.DownloadChunks(server.URL, chunks, output, nil, nil); err != nil {
+        t.Fatalf("initial DownloadChunks failed: %v", err);
+    }
+
+    serveContent = smallContent;
+    chunks = GetDownloadChunks(uint64(len(smallContent)), 4);
+    if err := getter.DownloadChunks(server.URL, chunks, output, nil, nil); err != nil {
+        t.Fatalf("second DownloadChunks failed: %v", err);
+    }
+
+    got, err := os.ReadFile(output);
+    if err != nil {
+        t.Fatalf("failed to read downloaded file: %v", err);
+    }
+    if !bytes.Equal(got, smallContent) {
+        t.Fatalf("re-downloaded file is %d bytes with stale trailing data, want %d bytes matching %q", len(got), len(smallContent), smallContent);
+    }
+}
+
+// probeReadCountingTransport wraps the body of any response to a Range
+// request so tests can observe how much of it the client actually read,
+// as opposed to how much the server wrote onto the wire.
+type probeReadCountingTransport struct {
+    inner      http.RoundTripper
+    probeBytes *int64
+}
+
+func (t *probeReadCountingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+    resp, err := t.inner.RoundTrip(req);
+    if err != nil || resp.Body == nil || req.Header.Get("Range") == "" {
+        return resp, err;
+    }
+    resp.Body = &countingReadCloser{ReadCloser: resp.Body, n: t.probeBytes};
+    return resp, nil;
+}
+
+type countingReadCloser struct {
+    io.ReadCloser
+    n *int64
+}
+
+func (c *countingReadCloser) Read(p []byte) (int, error) {
+    n, err := c.ReadCloser.Read(p);
+    atomic.AddInt64(c.n, int64(n));
+    return n, err;
+}
+
+// TestSupportsRangeProbeDoesNotDrainFullBody guards against a server that
+// advertises range support in its HEAD response but ignores the Range
+// header on the probing GET and answers with a full 200 body: the probe
+// must not read that body to completion, since downloadSingleStream is
+// about to fetch the whole resource again right after.
+func TestSupportsRangeProbeDoesNotDrainFullBody(t *testing.T) {
+    content := bytes.Repeat([]byte{0xAB}, 5*1024*1024);
+
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Content-Length", strconv.Itoa(len(content)));
+        if r.Method == http.MethodHead {
+            return;
+        }
+        w.Write(content);
+    }));
+    defer server.Close();
+
+    output := filepath.Join(t.TempDir(), "out.bin");
+    getter := NewGetter(4, 1);
+    var probeBytes int64;
+    getter.Client.Transport = &probeReadCountingTransport{inner: http.DefaultTransport, probeBytes: &probeBytes};
+
+    chunks := GetDownloadChunks(uint64(len(content)), 4);
+    if err := getter.DownloadChunks(server.URL, chunks, output, nil, nil); err != nil {
+        t.Fatalf("DownloadChunks failed: %v", err);
+    }
+
+    got, err := os.ReadFile(output);
+    if err != nil {
+        t.Fatalf("failed to read downloaded file: %v", err);
+    }
+    if !bytes.Equal(got, content) {
+        t.Fatalf("downloaded content does not match the served content");
+    }
+
+    if probeBytes >= int64(len(content)) {
+        t.Fatalf("probe GET read %d bytes of a %d byte resource, it should only peek the status code", probeBytes, len(content));
+    }
+}
+
+// TestResumeCompletesPartialDownload simulates an interrupted download by
+// writing a sidecar state file with some chunks already marked Done and
+// their bytes already on disk, then checks that Resume fetches only the
+// remaining chunks and produces the full, correct file.
+func TestResumeCompletesPartialDownload(t *testing.T) {
+    content := make([]byte, 256*1024);
+    for i := range content {
+        content[i] = byte(i);
+    }
+
+    var requested []string;
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if r.Method == http.MethodGet {
+            requested = append(requested, r.Header.Get("Range"));
+        }
+        http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(content));
+    }));
+    defer server.Close();
+
+    output := filepath.Join(t.TempDir(), "out.bin");
+    chunks := GetDownloadChunks(uint64(len(content)), 4);
+    state := &DownloadState{
+        Url: server.URL,
+        Output: output,
+        Size: uint64(len(content)),
+        Chunks: make([]ChunkState, len(chunks)),
+    };
+    for i, c := range chunks {
+        state.Chunks[i] = ChunkState{Start: c.Start, End: c.End, Done: i == 0};
+    }
+
+    if err := allocateOutput(output, state.Size); err != nil {
+        t.Fatalf("allocateOutput failed: %v", err);
+    }
+    f, err := os.OpenFile(output, os.O_WRONLY, 0644);
+    if err != nil {
+        t.Fatalf("failed to open output for seeding: %v", err);
+    }
+    if _, err := f.WriteAt(content[state.Chunks[0].Start:state.Chunks[0].End+1], int64(state.Chunks[0].Start)); err != nil {
+        t.Fatalf("failed to seed first chunk: %v", err);
+    }
+    f.Close();
+
+    statePath := stateFilePath(output);
+    if err := writeStateFile(statePath, state); err != nil {
+        t.Fatalf("writeStateFile failed: %v", err);
+    }
+
+    getter := NewGetter(4, 1);
+    if err := getter.Resume(output, nil, nil); err != nil {
+        t.Fatalf("Resume failed: %v", err);
+    }
+
+    got, err := os.ReadFile(output);
+    if err != nil {
+        t.Fatalf("failed to read downloaded file: %v", err);
+    }
+    if !bytes.Equal(got, content) {
+        t.Fatalf("resumed file does not match the served content");
+    }
+    if _, err := os.Stat(statePath); !os.IsNotExist(err) {
+        t.Fatalf("sidecar state file was not removed after Resume completed");
+    }
+    for _, r := range requested {
+        if strings.HasPrefix(r, fmt.Sprintf("bytes=%d-", chunks[0].Start)) {
+            t.Fatalf("Resume re-requested the already-Done first chunk: %q", r);
+        }
+    }
+}
+
+// TestResumeReportsAlreadyDoneChunksToProgress guards against a resumed
+// download under-reporting its progress: chunks already marked Done never
+// pass through the worker pool, so without replaying them to Progress up
+// front, BytesWritten would only ever total the bytes re-fetched this run.
+func TestResumeReportsAlreadyDoneChunksToProgress(t *testing.T) {
+    content := make([]byte, 256*1024);
+    for i := range content {
+        content[i] = byte(i);
+    }
+
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(content));
+    }));
+    defer server.Close();
+
+    output := filepath.Join(t.TempDir(), "out.bin");
+    chunks := GetDownloadChunks(uint64(len(content)), 4);
+    state := &DownloadState{
+        Url: server.URL,
+        Output: output,
+        Size: uint64(len(content)),
+        Chunks: make([]ChunkState, len(chunks)),
+    };
+    for i, c := range chunks {
+        state.Chunks[i] = ChunkState{Start: c.Start, End: c.End, Done: i < 3};
+    }
+
+    if err := allocateOutput(output, state.Size); err != nil {
+        t.Fatalf("allocateOutput failed: %v", err);
+    }
+    f, err := os.OpenFile(output, os.O_WRONLY, 0644);
+    if err != nil {
+        t.Fatalf("failed to open output for seeding: %v", err);
+    }
+    for i, c := range state.Chunks {
+        if !c.Done {
+            continue;
+        }
+        if _, err := f.WriteAt(content[c.Start:chunks[i].End+1], int64(c.Start)); err != nil {
+            t.Fatalf("failed to seed chunk %d: %v", i, err);
+        }
+    }
+    f.Close();
+
+    statePath := stateFilePath(output);
+    if err := writeStateFile(statePath, state); err != nil {
+        t.Fatalf("writeStateFile failed: %v", err);
+    }
+
+    var buf bytes.Buffer;
+    progress := NewJSONProgress(&buf);
+    getter := NewGetter(4, 1);
+    if err := getter.Resume(output, nil, progress); err != nil {
+        t.Fatalf("Resume failed: %v", err);
+    }
+
+    var totalWritten int64;
+    started := map[int]bool{};
+    completed := map[int]bool{};
+    for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+        var event progressEvent;
+        if err := json.Unmarshal([]byte(line), &event); err != nil {
+            t.Fatalf("failed to parse progress line %q: %v", line, err);
+        }
+        switch event.Event {
+        case "bytes_written":
+            totalWritten += event.Bytes;
+        case "chunk_started":
+            started[event.Index] = true;
+        case "chunk_completed":
+            completed[event.Index] = true;
+        }
+    }
+
+    if totalWritten != int64(len(content)) {
+        t.Fatalf("progress reported %d total bytes written, want %d", totalWritten, len(content));
+    }
+    for i := range state.Chunks {
+        if !started[i] {
+            t.Fatalf("chunk %d was never reported as started", i);
+        }
+        if !completed[i] {
+            t.Fatalf("chunk %d was never reported as completed", i);
+        }
+    }
+}
+
+// TestDownloadChunksRetryBackoffDoesNotStarvePool guards against a chunk's
+// exponential backoff sleep holding its slot in the shared chunk semaphore.
+// With the Getter's concurrency limited to 1, download "a"'s only chunk is
+// made to fail once and back off; once that failure has been observed,
+// download "b" (sharing the same Getter) must still be served well before
+// "a"'s backoff elapses, proving "a" released its slot before sleeping.
+func TestDownloadChunksRetryBackoffDoesNotStarvePool(t *testing.T) {
+    contentA := make([]byte, 64*1024);
+    contentB := make([]byte, 64*1024);
+    for i := range contentA {
+        contentA[i] = byte(i);
+        contentB[i] = byte(i + 1);
+    }
+
+    aFailed := make(chan struct{}, 1);
+    var failOnce sync.Once;
+
+    mux := http.NewServeMux();
+    mux.HandleFunc("/a", func(w http.ResponseWriter, r *http.Request) {
+        // Only the real chunk request (covering the whole file) should ever
+        // fail; supportsRange's own 1-byte probe GET must be left alone or
+        // DownloadChunks would wrongly fall back to a single-stream download.
+        var rangeStart, rangeEnd int64;
+        fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &rangeStart, &rangeEnd);
+        if r.Method == http.MethodGet && rangeEnd-rangeStart+1 >= int64(len(contentA)) {
+            failedThisRequest := false;
+            failOnce.Do(func() {
+                failedThisRequest = true;
+                w.WriteHeader(http.StatusInternalServerError);
+                aFailed <- struct{}{};
+            });
+            if failedThisRequest {
+                return;
+            }
+        }
+        http.ServeContent(w, r, "a.bin", time.Time{}, bytes.NewReader(contentA));
+    });
+    mux.HandleFunc("/b", func(w http.ResponseWriter, r *http.Request) {
+        http.ServeContent(w, r, "b.bin", time.Time{}, bytes.NewReader(contentB));
+    });
+    server := httptest.NewServer(mux);
+    defer server.Close();
+
+    getter := NewGetter(1, 1);
+    outputA := filepath.Join(t.TempDir(), "a.bin");
+    outputB := filepath.Join(t.TempDir(), "b.bin");
+
+    var wgA sync.WaitGroup;
+    wgA.Add(1);
+    var errA error;
+    go func() {
+        defer wgA.Done();
+        errA = getter.DownloadChunks(server.URL+"/a", GetDownloadChunks(uint64(len(contentA)), 1), outputA, nil, nil);
+    }();
+
+    <-aFailed;
+    bStart := time.Now();
+    if err := getter.DownloadChunks(server.URL+"/b", GetDownloadChunks(uint64(len(contentB)), 1), outputB, nil, nil); err != nil {
+        t.Fatalf("DownloadChunks for b failed: %v", err);
+    }
+    bElapsed := time.Since(bStart);
+
+    wgA.Wait();
+    if errA != nil {
+        t.Fatalf("DownloadChunks for a failed: %v", errA);
+    }
+
+    const backoff = 100 * time.Millisecond;
+    if bElapsed >= backoff {
+        t.Fatalf("download b took %s to acquire the shared chunk slot, expected it to run well within a's %s backoff rather than wait for it", bElapsed, backoff);
+    }
+
+    gotA, err := os.ReadFile(outputA);
+    if err != nil {
+        t.Fatalf("failed to read %s: %v", outputA, err);
+    }
+    if !bytes.Equal(gotA, contentA) {
+        t.Fatalf("download a's content does not match the served content");
+    }
+    gotB, err := os.ReadFile(outputB);
+    if err != nil {
+        t.Fatalf("failed to read %s: %v", outputB, err);
+    }
+    if !bytes.Equal(gotB, contentB) {
+        t.Fatalf("download b's content does not match the served content");
+    }
+}
+
+// TestDownloadChunksSplitsPersistentlyFailingChunk exercises the adaptive
+// retry/split worker pool in downloadChunksWithState: a chunk too large for
+// the server to serve keeps failing until it's split into smaller halves,
+// which succeed.
+func TestDownloadChunksSplitsPersistentlyFailingChunk(t *testing.T) {
+    content := make([]byte, 200*1024);
+    for i := range content {
+        content[i] = byte(i);
+    }
+
+    var mu sync.Mutex;
+    var requests int;
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        mu.Lock();
+        requests++;
+        mu.Unlock();
+
+        var start, end int64;
+        fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end);
+        if end-start+1 > minSplitChunkSize*2 {
+            w.WriteHeader(http.StatusInternalServerError);
+            return;
+        }
+        http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(content));
+    }));
+    defer server.Close();
+
+    output := filepath.Join(t.TempDir(), "out.bin");
+    getter := NewGetter(4, 1);
+    chunks := GetDownloadChunks(uint64(len(content)), 1);
+    if err := getter.DownloadChunks(server.URL, chunks, output, nil, nil); err != nil {
+        t.Fatalf("DownloadChunks failed: %v", err);
+    }
+
+    got, err := os.ReadFile(output);
+    if err != nil {
+        t.Fatalf("failed to read downloaded file: %v", err);
+    }
+    if !bytes.Equal(got, content) {
+        t.Fatalf("downloaded content does not match the served content");
+    }
+    if requests < 3 {
+        t.Fatalf("expected the oversized chunk to be retried and then split into smaller requests, saw only %d requests", requests);
+    }
+}
+
+// TestDownloadChunksSpeculativeFallbackVerifiesChecksum exercises
+// downloadSpeculative against a server that supports Range requests but
+// never reports a Content-Length, forcing more than one speculativeChunkSize
+// request so the "stop once a request comes back short" logic is exercised.
+func TestDownloadChunksSpeculativeFallbackVerifiesChecksum(t *testing.T) {
+    content := make([]byte, speculativeChunkSize+1024);
+    for i := range content {
+        content[i] = byte(i);
+    }
+    sum := sha256.Sum256(content);
+
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Accept-Ranges", "bytes");
+
+        var start, end int64;
+        fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-%d", &start, &end);
+        if start >= int64(len(content)) {
+            w.WriteHeader(http.StatusPartialContent);
+            return;
+        }
+        if end >= int64(len(content)) {
+            end = int64(len(content)) - 1;
+        }
+
+        // Flushing before writing forces chunked transfer encoding, so Go's
+        // net/http never fills in a Content-Length - the thing that drives
+        // a server down this fallback path in the first place.
+        w.WriteHeader(http.StatusPartialContent);
+        w.(http.Flusher).Flush();
+        w.Write(content[start : end+1]);
+    }));
+    defer server.Close();
+
+    getter := NewGetter(4, 1);
+
+    good, err := NewChecksumVerifier("sha256:" + hex.EncodeToString(sum[:]));
+    if err != nil {
+        t.Fatalf("NewChecksumVerifier failed: %v", err);
+    }
+    output := filepath.Join(t.TempDir(), "out.bin");
+    if err := getter.DownloadChunks(server.URL, nil, output, good, nil); err != nil {
+        t.Fatalf("DownloadChunks failed: %v", err);
+    }
+    got, err := os.ReadFile(output);
+    if err != nil {
+        t.Fatalf("failed to read downloaded file: %v", err);
+    }
+    if !bytes.Equal(got, content) {
+        t.Fatalf("downloaded content does not match the served content");
+    }
+
+    bad, err := NewChecksumVerifier("sha256:" + strings.Repeat("0", 64));
+    if err != nil {
+        t.Fatalf("NewChecksumVerifier failed: %v", err);
+    }
+    badOutput := filepath.Join(t.TempDir(), "bad.bin");
+    if err := getter.DownloadChunks(server.URL, nil, badOutput, bad, nil); err == nil {
+        t.Fatalf("expected checksum verification to fail for a wrong checksum");
+    }
+    if _, err := os.Stat(badOutput); !os.IsNotExist(err) {
+        t.Fatalf("corrupt file was not deleted after checksum verification failed");
+    }
+}
+
+// TestRunMultifileDownloadsAllEntries exercises runMultifile and
+// Getter.DownloadFile end to end against a JSON manifest naming two
+// different servers, checking that every entry lands at its own output path.
+func TestRunMultifileDownloadsAllEntries(t *testing.T) {
+    contentA := []byte("hello multifile a");
+    contentB := []byte("hello multifile b, a little bit longer than a");
+
+    serverA := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        http.ServeContent(w, r, "a.bin", time.Time{}, bytes.NewReader(contentA));
+    }));
+    defer serverA.Close();
+    serverB := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        http.ServeContent(w, r, "b.bin", time.Time{}, bytes.NewReader(contentB));
+    }));
+    defer serverB.Close();
+
+    dir := t.TempDir();
+    outputA := filepath.Join(dir, "a.out");
+    outputB := filepath.Join(dir, "b.out");
+
+    entries := []manifest.Entry{
+        {Url: serverA.URL, Output: outputA},
+        {Url: serverB.URL, Output: outputB},
+    };
+    data, err := json.Marshal(entries);
+    if err != nil {
+        t.Fatalf("failed to marshal manifest: %v", err);
+    }
+    manifestPath := filepath.Join(dir, "manifest.json");
+    if err := os.WriteFile(manifestPath, data, 0644); err != nil {
+        t.Fatalf("failed to write manifest: %v", err);
+    }
+
+    if err := runMultifile([]string{"-threads", "2", manifestPath}); err != nil {
+        t.Fatalf("runMultifile failed: %v", err);
+    }
+
+    gotA, err := os.ReadFile(outputA);
+    if err != nil {
+        t.Fatalf("failed to read %s: %v", outputA, err);
+    }
+    if !bytes.Equal(gotA, contentA) {
+        t.Fatalf("entry a's downloaded content does not match the served content");
+    }
+
+    gotB, err := os.ReadFile(outputB);
+    if err != nil {
+        t.Fatalf("failed to read %s: %v", outputB, err);
+    }
+    if !bytes.Equal(gotB, contentB) {
+        t.Fatalf("entry b's downloaded content does not match the served content");
+    }
+}
+
+// TestRunMultifileFallsBackWhenSizeUnknown exercises Getter.DownloadFile
+// against a server that never reports a Content-Length (e.g. chunked
+// transfer encoding), checking that the manifest entry still downloads via
+// the single-stream/speculative fallback instead of failing outright.
+func TestRunMultifileFallsBackWhenSizeUnknown(t *testing.T) {
+    content := []byte("a manifest entry served without a content length");
+
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        w.Header().Set("Accept-Ranges", "none");
+        // Flushing before writing keeps net/http from computing a
+        // Content-Length for this small body, so the size stays unknown.
+        w.(http.Flusher).Flush();
+        w.Write(content);
+    }));
+    defer server.Close();
+
+    dir := t.TempDir();
+    output := filepath.Join(dir, "out.bin");
+
+    entries := []manifest.Entry{{Url: server.URL, Output: output}};
+    data, err := json.Marshal(entries);
+    if err != nil {
+        t.Fatalf("failed to marshal manifest: %v", err);
+    }
+    manifestPath := filepath.Join(dir, "manifest.json");
+    if err := os.WriteFile(manifestPath, data, 0644); err != nil {
+        t.Fatalf("failed to write manifest: %v", err);
+    }
+
+    if err := runMultifile([]string{manifestPath}); err != nil {
+        t.Fatalf("runMultifile failed: %v", err);
+    }
+
+    got, err := os.ReadFile(output);
+    if err != nil {
+        t.Fatalf("failed to read %s: %v", output, err);
+    }
+    if !bytes.Equal(got, content) {
+        t.Fatalf("downloaded content does not match the served content");
+    }
+}
+
+// TestFetchStreamsChunksInOrder exercises Fetch and chanMultiReader: the
+// server is made to answer the earliest chunk last, so the returned reader
+// must still yield bytes in order rather than in completion order.
+func TestFetchStreamsChunksInOrder(t *testing.T) {
+    content := make([]byte, 256*1024);
+    for i := range content {
+        content[i] = byte(i);
+    }
+
+    server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        var start int64;
+        fmt.Sscanf(r.Header.Get("Range"), "bytes=%d-", &start);
+        if start == 0 {
+            time.Sleep(100 * time.Millisecond);
+        }
+        http.ServeContent(w, r, "file.bin", time.Time{}, bytes.NewReader(content));
+    }));
+    defer server.Close();
+
+    getter := NewGetter(4, 1);
+    body, size, err := getter.Fetch(server.URL);
+    if err != nil {
+        t.Fatalf("Fetch failed: %v", err);
+    }
+    defer body.Close();
+
+    if size != int64(len(content)) {
+        t.Fatalf("Fetch reported size %d, want %d", size, len(content));
+    }
+
+    got, err := io.ReadAll(body);
+    if err != nil {
+        t.Fatalf("failed to read Fetch's reader: %v", err);
+    }
+    if !bytes.Equal(got, content) {
+        t.Fatalf("Fetch's reader did not yield bytes in order");
+    }
+}
@@ -0,0 +1,88 @@
+package manifest
+
+import (
+    "bufio"
+    "encoding/json"
+    "fmt"
+    "os"
+    "strings"
+)
+
+// Entry is one <url, output-path> pair to download from a manifest file.
+// Checksum is optional and, if set, overrides the manifest-wide --checksum
+// flag for this entry only. It's only honoured for JSON manifests, since the
+// plain text format has no room for a third field.
+type Entry struct {
+    Url string `json:"url"`
+    Output string `json:"output"`
+    Checksum string `json:"checksum,omitempty"`
+};
+
+/*
+Parse reads a manifest describing files to download. Manifests ending in
+.json are decoded as a JSON array of Entry; anything else is read as a
+plain text file with one "<url> <output-path>" pair per line, with blank
+lines and lines starting with # ignored.
+
+Arguments:
+    - path (string): The path of the manifest file.
+
+Returns:
+    - []Entry: The entries described by the manifest.
+    - error: The error if any occured.
+
+Example:
+    entries, err := manifest.Parse("./downloads.txt");
+    if err != nil {
+        return err;
+    }
+*/
+func Parse(path string) ([]Entry, error) {
+    if strings.HasSuffix(path, ".json") {
+        return parseJSON(path);
+    }
+    return parseText(path);
+}
+
+func parseJSON(path string) ([]Entry, error) {
+    data, err := os.ReadFile(path);
+    if err != nil {
+        return nil, fmt.Errorf("Error: Failed to read manifest %s. %v", path, err);
+    }
+
+    var entries []Entry;
+    if err := json.Unmarshal(data, &entries); err != nil {
+        return nil, fmt.Errorf("Error: Failed to decode manifest %s. %v", path, err);
+    }
+
+    return entries, nil;
+}
+
+func parseText(path string) ([]Entry, error) {
+    f, err := os.Open(path);
+    if err != nil {
+        return nil, fmt.Errorf("Error: Failed to read manifest %s. %v", path, err);
+    }
+    defer f.Close();
+
+    var entries []Entry;
+    scanner := bufio.NewScanner(f);
+    for scanner.Scan() {
+        line := strings.TrimSpace(scanner.Text());
+        if line == "" || strings.HasPrefix(line, "#") {
+            continue;
+        }
+
+        fields := strings.Fields(line);
+        if len(fields) != 2 {
+            return nil, fmt.Errorf("Error: Invalid manifest line %q in %s.", line, path);
+        }
+
+        entries = append(entries, Entry{Url: fields[0], Output: fields[1]});
+    }
+    if err := scanner.Err(); err != nil {
+        return nil, fmt.Errorf("Error: Failed to read manifest %s. %v", path, err);
+    }
+
+    return entries, nil;
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "io"
+    "os"
+    "sync"
+    "time"
+);
+
+/*
+Progress lets a caller observe a download as it happens, e.g. to render a
+terminal progress bar or emit machine-readable events instead of the fixed
+"Log: ..." lines DownloadChunks used to print. Its methods are called from
+every chunk's goroutine concurrently, so implementations must be safe for
+concurrent use.
+*/
+type Progress interface {
+    // ChunkStarted is called as a chunk's download begins, with
+    // the chunk's index into the overall download and its size in bytes.
+    ChunkStarted(index int, size int64)
+    // BytesWritten is called as a chunk's body streams in, with the number
+    // of bytes just read, so progress is visible before the chunk finishes.
+    BytesWritten(n int64)
+    // ChunkCompleted is called once a chunk has landed on disk.
+    ChunkCompleted(index int)
+    // Finished is called once the whole download has completed.
+    Finished()
+    // Message reports a one-off informational event not tied to a specific
+    // chunk, e.g. which download strategy was chosen for a server.
+    Message(msg string)
+    // WithFile returns a Progress that reports the same events tagged as
+    // belonging to file, so a caller driving several concurrent downloads
+    // through one shared Progress (e.g. multifile) can tell them apart.
+    WithFile(file string) Progress
+};
+
+/*
+countingReader wraps a chunk response body so every Read reports its byte
+count to a Progress, rather than only reporting once the whole chunk has
+been buffered and written to disk.
+*/
+type countingReader struct {
+    io.Reader
+    progress Progress
+};
+
+func (r *countingReader) Read(p []byte) (int, error) {
+    n, err := r.Reader.Read(p);
+    if n > 0 && r.progress != nil {
+        r.progress.BytesWritten(int64(n));
+    }
+    return n, err;
+}
+
+// terminalProgress renders a single-line text bar to w, redrawing it in
+// place the way ioprogress' DrawTextFormatBar does.
+type terminalProgress struct {
+    w io.Writer
+    total int64
+
+    mu sync.Mutex
+    written int64
+    start time.Time
+};
+
+/*
+NewTerminalProgress builds a Progress that draws a redrawing-in-place
+progress bar (percent, bytes, throughput and ETA) to w as the download
+proceeds.
+
+Arguments:
+    - w (io.Writer): Where to draw the bar, typically os.Stdout.
+    - total (int64): The expected total size of the download in bytes, or 0
+      if it isn't known up front, in which case no percent or ETA is shown.
+
+Returns:
+    - Progress: The configured progress bar.
+
+Example:
+    progress := NewTerminalProgress(os.Stdout, int64(size));
+*/
+func NewTerminalProgress(w io.Writer, total int64) Progress {
+    return &terminalProgress{w: w, total: total};
+}
+
+func (p *terminalProgress) ChunkStarted(index int, size int64) {}
+
+func (p *terminalProgress) BytesWritten(n int64) {
+    p.mu.Lock();
+    defer p.mu.Unlock();
+    if p.written == 0 {
+        p.start = time.Now();
+    }
+    p.written += n;
+    p.draw();
+}
+
+func (p *terminalProgress) ChunkCompleted(index int) {}
+
+func (p *terminalProgress) Finished() {
+    p.mu.Lock();
+    defer p.mu.Unlock();
+    fmt.Fprintln(p.w);
+}
+
+func (p *terminalProgress) Message(msg string) {
+    p.mu.Lock();
+    defer p.mu.Unlock();
+    fmt.Fprintln(p.w, msg);
+}
+
+// WithFile is a no-op for terminalProgress: a single redrawing-in-place bar
+// isn't meaningful for more than one concurrent download, so multifile
+// reports progress only as JSON (with --json-progress) or not at all.
+func (p *terminalProgress) WithFile(file string) Progress {
+    return p;
+}
+
+func (p *terminalProgress) draw() {
+    elapsed := time.Since(p.start).Seconds();
+    var rate float64;
+    if elapsed > 0 {
+        rate = float64(p.written) / elapsed;
+    }
+
+    if p.total > 0 {
+        pct := float64(p.written) / float64(p.total) * 100;
+        var eta time.Duration;
+        if rate > 0 {
+            eta = (time.Duration(float64(p.total-p.written)/rate) * time.Second).Round(time.Second);
+        }
+        fmt.Fprintf(p.w, "\r%6.2f%%  %d/%d bytes  %.2f MB/s  ETA %s   ", pct, p.written, p.total, rate/(1024*1024), eta);
+    } else {
+        fmt.Fprintf(p.w, "\r%d bytes  %.2f MB/s   ", p.written, rate/(1024*1024));
+    }
+}
+
+// progressEvent is the JSON shape jsonProgress emits, one per line. Fields
+// that don't apply to an event (e.g. Size on a bytes_written event) are
+// omitted rather than sent as zero values.
+type progressEvent struct {
+    Event string `json:"event"`
+    File string `json:"file,omitempty"`
+    Index int `json:"index,omitempty"`
+    Size int64 `json:"size,omitempty"`
+    Bytes int64 `json:"bytes,omitempty"`
+    Message string `json:"message,omitempty"`
+};
+
+// jsonProgress emits one JSON object per line to w for each progress event,
+// for callers that want to consume a download's progress programmatically.
+type jsonProgress struct {
+    w io.Writer
+    mu sync.Mutex
+};
+
+/*
+NewJSONProgress builds a Progress that writes one JSON-encoded
+progressEvent per line to w as the download proceeds.
+
+Arguments:
+    - w (io.Writer): Where to write the JSON lines, typically os.Stdout.
+
+Returns:
+    - Progress: The configured JSON-lines emitter.
+
+Example:
+    progress := NewJSONProgress(os.Stdout);
+*/
+func NewJSONProgress(w io.Writer) Progress {
+    return &jsonProgress{w: w};
+}
+
+func (p *jsonProgress) emit(event progressEvent) {
+    data, err := json.Marshal(event);
+    if err != nil {
+        return;
+    }
+
+    p.mu.Lock();
+    defer p.mu.Unlock();
+    fmt.Fprintln(p.w, string(data));
+}
+
+func (p *jsonProgress) ChunkStarted(index int, size int64) {
+    p.emit(progressEvent{Event: "chunk_started", Index: index, Size: size});
+}
+
+func (p *jsonProgress) BytesWritten(n int64) {
+    p.emit(progressEvent{Event: "bytes_written", Bytes: n});
+}
+
+func (p *jsonProgress) ChunkCompleted(index int) {
+    p.emit(progressEvent{Event: "chunk_completed", Index: index});
+}
+
+func (p *jsonProgress) Finished() {
+    p.emit(progressEvent{Event: "finished"});
+}
+
+func (p *jsonProgress) Message(msg string) {
+    p.emit(progressEvent{Event: "message", Message: msg});
+}
+
+func (p *jsonProgress) WithFile(file string) Progress {
+    return &taggedJSONProgress{p: p, file: file};
+}
+
+// taggedJSONProgress wraps a jsonProgress so every event it emits carries
+// a File field, letting a consumer of --json-progress tell apart the
+// interleaved events from several files downloading concurrently through
+// one shared Progress.
+type taggedJSONProgress struct {
+    p *jsonProgress
+    file string
+};
+
+func (t *taggedJSONProgress) ChunkStarted(index int, size int64) {
+    t.p.emit(progressEvent{Event: "chunk_started", File: t.file, Index: index, Size: size});
+}
+
+func (t *taggedJSONProgress) BytesWritten(n int64) {
+    t.p.emit(progressEvent{Event: "bytes_written", File: t.file, Bytes: n});
+}
+
+func (t *taggedJSONProgress) ChunkCompleted(index int) {
+    t.p.emit(progressEvent{Event: "chunk_completed", File: t.file, Index: index});
+}
+
+func (t *taggedJSONProgress) Finished() {
+    t.p.emit(progressEvent{Event: "finished", File: t.file});
+}
+
+func (t *taggedJSONProgress) Message(msg string) {
+    t.p.emit(progressEvent{Event: "message", File: t.file, Message: msg});
+}
+
+func (t *taggedJSONProgress) WithFile(file string) Progress {
+    return &taggedJSONProgress{p: t.p, file: file};
+}
+
+/*
+newProgress builds the Progress implementation named by mode for the
+gofast CLI: "bar" for a terminal progress bar, "json" for JSON-lines
+events, or "none" (or anything else) to skip progress reporting.
+
+Arguments:
+    - mode (string): "bar", "json" or "none".
+    - total (int64): The expected total size of the download in bytes, used
+      by the "bar" mode. Pass 0 if it isn't known up front.
+
+Returns:
+    - Progress: The configured Progress, or nil for "none".
+*/
+func newProgress(mode string, total int64) Progress {
+    switch mode {
+    case "bar":
+        return NewTerminalProgress(os.Stdout, total);
+    case "json":
+        return NewJSONProgress(os.Stdout);
+    default:
+        return nil;
+    }
+}
+
+/*
+logMessage reports msg through progress's Message callback if one is
+configured, or prints it directly to stdout otherwise. Routing through
+progress keeps informational messages from interleaving with a configured
+--progress json stream, while "none" mode (or a caller that didn't
+configure a Progress at all) still sees them.
+
+Arguments:
+    - progress (Progress): The progress reporter to notify, or nil to
+      print directly.
+    - msg (string): The message to report.
+*/
+func logMessage(progress Progress, msg string) {
+    if progress != nil {
+        progress.Message(msg);
+        return;
+    }
+    fmt.Printf("Log: %s\n", msg);
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "strings"
+    "testing"
+);
+
+// decodeProgressEvents parses one progressEvent per line of buf, failing the
+// test if any line isn't valid JSON.
+func decodeProgressEvents(t *testing.T, buf *bytes.Buffer) []progressEvent {
+    t.Helper();
+    var events []progressEvent;
+    for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+        var event progressEvent;
+        if err := json.Unmarshal([]byte(line), &event); err != nil {
+            t.Fatalf("failed to parse progress line %q: %v", line, err);
+        }
+        events = append(events, event);
+    }
+    return events;
+}
+
+// TestJSONProgressEmitsEventsPerLine checks that jsonProgress emits one
+// well-formed JSON object per line, with each event's fields populated and
+// fields that don't apply to it (e.g. File on an untagged Progress) omitted.
+func TestJSONProgressEmitsEventsPerLine(t *testing.T) {
+    var buf bytes.Buffer;
+    p := NewJSONProgress(&buf);
+
+    p.ChunkStarted(2, 1024);
+    p.BytesWritten(512);
+    p.ChunkCompleted(2);
+    p.Message("hello");
+    p.Finished();
+
+    events := decodeProgressEvents(t, &buf);
+    want := []progressEvent{
+        {Event: "chunk_started", Index: 2, Size: 1024},
+        {Event: "bytes_written", Bytes: 512},
+        {Event: "chunk_completed", Index: 2},
+        {Event: "message", Message: "hello"},
+        {Event: "finished"},
+    };
+    if len(events) != len(want) {
+        t.Fatalf("got %d events, want %d: %+v", len(events), len(want), events);
+    }
+    for i, w := range want {
+        if events[i] != w {
+            t.Fatalf("event %d = %+v, want %+v", i, events[i], w);
+        }
+        if events[i].File != "" {
+            t.Fatalf("event %d has a File field %q, want it omitted for an untagged Progress", i, events[i].File);
+        }
+    }
+}
+
+// TestTaggedJSONProgressTagsEveryEvent checks that WithFile's returned
+// Progress stamps every event, of every kind, with its file name.
+func TestTaggedJSONProgressTagsEveryEvent(t *testing.T) {
+    var buf bytes.Buffer;
+    p := NewJSONProgress(&buf).WithFile("part-a.bin");
+
+    p.ChunkStarted(0, 2048);
+    p.BytesWritten(2048);
+    p.ChunkCompleted(0);
+    p.Message("done");
+    p.Finished();
+
+    events := decodeProgressEvents(t, &buf);
+    wantEvents := []string{"chunk_started", "bytes_written", "chunk_completed", "message", "finished"};
+    if len(events) != len(wantEvents) {
+        t.Fatalf("got %d events, want %d: %+v", len(events), len(wantEvents), events);
+    }
+    for i, event := range events {
+        if event.Event != wantEvents[i] {
+            t.Fatalf("event %d has type %q, want %q", i, event.Event, wantEvents[i]);
+        }
+        if event.File != "part-a.bin" {
+            t.Fatalf("event %d (%s) has File %q, want %q", i, event.Event, event.File, "part-a.bin");
+        }
+    }
+}
+
+// TestTaggedJSONProgressWithFileRetags checks that calling WithFile again on
+// an already-tagged Progress switches the tag rather than nesting it, the
+// way multifile hands each download its own per-file view of one shared
+// Progress.
+func TestTaggedJSONProgressWithFileRetags(t *testing.T) {
+    var buf bytes.Buffer;
+    shared := NewJSONProgress(&buf);
+
+    shared.WithFile("a.bin").ChunkStarted(0, 10);
+    shared.WithFile("a.bin").WithFile("b.bin").ChunkStarted(0, 20);
+
+    events := decodeProgressEvents(t, &buf);
+    if len(events) != 2 {
+        t.Fatalf("got %d events, want 2: %+v", len(events), events);
+    }
+    if events[0].File != "a.bin" || events[1].File != "b.bin" {
+        t.Fatalf("events tagged %q and %q, want %q and %q", events[0].File, events[1].File, "a.bin", "b.bin");
+    }
+}